@@ -8,6 +8,7 @@ import (
 	"io"
 	"io/ioutil"
 	"log"
+	"log/slog"
 	"net"
 	"net/http"
 	"os"
@@ -16,42 +17,118 @@ import (
 	"time"
 
 	"github.com/fsnotify/fsnotify"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/yang478/STUN-Port-Redirector/ratelimit"
+	"github.com/yang478/STUN-Port-Redirector/stun"
 )
 
 // JSON 文件路径
 const (
 	jsonFilePath        = "/app/data.json"
 	redirectMappingPath = "/app/redirect_mapping.json"
+	stunConfigPath      = "/app/stun_config.json"
 	debounceTime        = 100 * time.Millisecond // 去抖动时间
+
+	defaultStunRefreshInterval = 60 * time.Second
+	defaultStunTimeout         = 3 * time.Second
+	defaultStunInitialBackoff  = 1 * time.Second
+	defaultStunMaxBackoff      = 60 * time.Second
+)
+
+// 重定向规则的转发模式
+const (
+	redirectModeRedirect = "redirect" // 默认：302 跳转
+	redirectModeProxy    = "proxy"    // 反向代理
+	redirectModeProxyWS  = "proxy+ws" // 反向代理，支持 WebSocket 升级
 )
 
 // 从环境变量中读取 Bearer Token
 var validBearerToken = os.Getenv("BEARER_TOKEN")
 
+// structuredLogger 为数据面/配置面的热路径（redirectHandler、配置加载/写回）
+// 输出带字段的 JSON 日志，便于基于 host/port/key/remote_addr/matched_rule 建
+// 立仪表盘和告警。其余次要路径继续使用标准库 log，与历史代码保持一致。
+var structuredLogger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+
+// RateLimitConfig 是 redirect_mapping.json 顶层 "_ratelimit_defaults" 块的格式，
+// 为没有显式配置限流的规则（以及 API 服务器）提供默认值。
+type RateLimitConfig struct {
+	RPS   float64 `json:"rps"`
+	Burst int     `json:"burst"`
+	Per   string  `json:"per"`
+}
+
 // 内存缓存
 var (
 	dataCache        map[string]interface{}
-	redirectMapping  map[string]string
+	redirectRules    []Rule // 按文件顺序排列，redirectHandler 按第一个匹配的规则处理
 	dataCacheLock    sync.RWMutex
 	redirectLock     sync.RWMutex
 	lastDataHash     string
 	lastRedirectHash string
-	debounceTimer    *time.Timer
+)
+
+// redirectDefaultsKey 是 redirect_mapping.json 中保留给限流默认值的键，不会
+// 被当作一条普通的重定向规则加载。
+const redirectDefaultsKey = "_ratelimit_defaults"
+
+// 限流相关的内存状态
+var (
+	rateLimitDefaults     RateLimitConfig
+	rateLimitDefaultsLock sync.RWMutex
+
+	// redirectLimiterStore 既服务于 API 服务器 (/api/save, /api/get)，也服务于
+	// 每条重定向规则，key 形如 "<rule>|<ip>" 或者 Per=="global" 时的 "<rule>"。
+	redirectLimiterStore = ratelimit.NewStore(5*time.Minute, 10000)
+)
+
+// StunConfig 描述 STUN 探测使用的服务器列表与时间参数，由 stun_config.json 提供。
+type StunConfig struct {
+	Servers            []string `json:"servers"`
+	RefreshIntervalSec int      `json:"refresh_interval_seconds"`
+	TimeoutSec         int      `json:"timeout_seconds"`
+	InitialBackoffSec  int      `json:"initial_backoff_seconds"`
+	MaxBackoffSec      int      `json:"max_backoff_seconds"`
+}
+
+// StunStatus 记录最近一次 STUN 探测的结果，供 /api/stun/status 查询。
+type StunStatus struct {
+	LastAddr       string    `json:"last_addr"`
+	LastPort       int       `json:"last_port"`
+	LastServer     string    `json:"last_server"`
+	LastRTTMillis  int64     `json:"last_rtt_ms"`
+	LastChangeTime time.Time `json:"last_change_time"`
+	LastError      string    `json:"last_error,omitempty"`
+}
+
+// STUN 探测相关的内存状态
+var (
+	stunConfig         StunConfig
+	stunConfigLock     sync.RWMutex
+	lastStunConfigHash string
+
+	stunStatus     StunStatus
+	stunStatusLock sync.RWMutex
+
+	stunBoundPort int // 当前写入 redirectRules 的 STUN 映射端口，0 表示尚未建立
 )
 
 // 初始化缓存
 func init() {
-	// 检查环境变量是否设置
-	if validBearerToken == "" {
-		log.Fatalf("BEARER_TOKEN environment variable is not set")
-	}
-
 	dataCache = make(map[string]interface{})
-	redirectMapping = make(map[string]string)
+	redirectRules = nil
 
 	// 初始加载数据
 	loadDataToCache()
 	loadRedirectMapping()
+	loadStunConfig()
+	loadListenersConfig()
+
+	// data.json 里持久化的 port 就是上次 STUN 探测绑定的端口（NAT 映射重启后
+	// 通常不变）。在 runStunLoop 开始前把它当作已绑定端口，否则第一轮 STUN
+	// 结果和 data.json 一致、不触发 rebuildRedirectMappingForPort 时，
+	// stunBoundPort 会一直停在 0，导致下一次真正的端口变化找不到旧规则迁移。
+	seedStunBoundPort()
 
 	// 启动定时任务，定期将缓存写入文件
 	go func() {
@@ -64,6 +141,11 @@ func init() {
 	// 启动文件监听
 	go watchFiles(redirectMappingPath, loadRedirectMapping)
 	go watchFiles(jsonFilePath, loadDataToCache)
+	go watchFiles(stunConfigPath, loadStunConfig)
+	go watchFiles(listenersConfigPath, loadListenersConfig)
+
+	// 启动 STUN 探测循环，持续发现外部映射端口
+	go runStunLoop()
 }
 
 // 计算文件内容的 MD5 哈希值
@@ -84,15 +166,19 @@ func getFileHash(filePath string) (string, error) {
 
 // 从文件加载数据到缓存
 func loadDataToCache() {
+	start := time.Now()
+
 	// 计算当前文件内容的哈希值
 	currentHash, err := getFileHash(jsonFilePath)
 	if err != nil {
-		log.Printf("Failed to calculate file hash: %v", err)
+		structuredLogger.Error("Failed to calculate file hash", "file", jsonFilePath, "error", err)
+		configReloadTotal.WithLabelValues(jsonFilePath, "error").Inc()
 		return
 	}
 
 	// 如果哈希值没有变化，则跳过重新加载
 	if currentHash == lastDataHash {
+		configReloadTotal.WithLabelValues(jsonFilePath, "skipped").Inc()
 		return
 	}
 
@@ -101,7 +187,8 @@ func loadDataToCache() {
 
 	file, err := os.Open(jsonFilePath)
 	if err != nil {
-		log.Printf("Failed to open JSON file: %v", err)
+		structuredLogger.Error("Failed to open JSON file", "file", jsonFilePath, "error", err)
+		configReloadTotal.WithLabelValues(jsonFilePath, "error").Inc()
 		return
 	}
 	defer file.Close()
@@ -109,31 +196,40 @@ func loadDataToCache() {
 	// 检查文件大小，避免解码空文件
 	fileInfo, err := file.Stat()
 	if err != nil {
-		log.Printf("Failed to stat JSON file: %v", err)
+		structuredLogger.Error("Failed to stat JSON file", "file", jsonFilePath, "error", err)
+		configReloadTotal.WithLabelValues(jsonFilePath, "error").Inc()
 		return
 	}
 	if fileInfo.Size() == 0 {
-		log.Println("JSON file is empty, skipping decode.")
+		structuredLogger.Info("JSON file is empty, skipping decode", "file", jsonFilePath)
+		configReloadTotal.WithLabelValues(jsonFilePath, "skipped").Inc()
 		return
 	}
 
 	decoder := json.NewDecoder(file)
 	if err := decoder.Decode(&dataCache); err != nil {
-		log.Printf("Failed to decode JSON file: %v", err)
+		structuredLogger.Error("Failed to decode JSON file", "file", jsonFilePath, "error", err)
+		configReloadTotal.WithLabelValues(jsonFilePath, "error").Inc()
 		return
 	}
 
-	log.Println("Data reloaded successfully.")
+	mappingSize.WithLabelValues(jsonFilePath).Set(float64(len(dataCache)))
+	configReloadDuration.WithLabelValues(jsonFilePath).Observe(time.Since(start).Seconds())
+	configReloadTotal.WithLabelValues(jsonFilePath, "success").Inc()
+	structuredLogger.Info("Data reloaded successfully", "file", jsonFilePath)
 }
 
 // 将缓存写入文件
 func saveCacheToFile() {
+	start := time.Now()
+
 	dataCacheLock.RLock()
 	defer dataCacheLock.RUnlock()
 
 	file, err := os.Create(jsonFilePath)
 	if err != nil {
-		log.Printf("Failed to create JSON file: %v", err)
+		structuredLogger.Error("Failed to create JSON file", "file", jsonFilePath, "error", err)
+		configReloadTotal.WithLabelValues(jsonFilePath, "write_error").Inc()
 		return
 	}
 	defer file.Close()
@@ -141,21 +237,30 @@ func saveCacheToFile() {
 	encoder := json.NewEncoder(file)
 	encoder.SetIndent("", "    ")
 	if err := encoder.Encode(dataCache); err != nil {
-		log.Printf("Failed to encode JSON data: %v", err)
+		structuredLogger.Error("Failed to encode JSON data", "file", jsonFilePath, "error", err)
+		configReloadTotal.WithLabelValues(jsonFilePath, "write_error").Inc()
+		return
 	}
+
+	configReloadDuration.WithLabelValues(jsonFilePath).Observe(time.Since(start).Seconds())
+	configReloadTotal.WithLabelValues(jsonFilePath, "write_success").Inc()
 }
 
 // 加载重定向映射表
 func loadRedirectMapping() {
+	start := time.Now()
+
 	// 计算当前文件内容的哈希值
 	currentHash, err := getFileHash(redirectMappingPath)
 	if err != nil {
-		log.Printf("Failed to calculate file hash: %v", err)
+		structuredLogger.Error("Failed to calculate file hash", "file", redirectMappingPath, "error", err)
+		configReloadTotal.WithLabelValues(redirectMappingPath, "error").Inc()
 		return
 	}
 
 	// 如果哈希值没有变化，则跳过重新加载
 	if currentHash == lastRedirectHash {
+		configReloadTotal.WithLabelValues(redirectMappingPath, "skipped").Inc()
 		return
 	}
 
@@ -164,7 +269,8 @@ func loadRedirectMapping() {
 
 	file, err := os.Open(redirectMappingPath)
 	if err != nil {
-		log.Printf("Failed to open redirect mapping file: %v", err)
+		structuredLogger.Error("Failed to open redirect mapping file", "file", redirectMappingPath, "error", err)
+		configReloadTotal.WithLabelValues(redirectMappingPath, "error").Inc()
 		return
 	}
 	defer file.Close()
@@ -172,21 +278,345 @@ func loadRedirectMapping() {
 	// 检查文件大小，避免解码空文件
 	fileInfo, err := file.Stat()
 	if err != nil {
-		log.Printf("Failed to stat redirect mapping file: %v", err)
+		structuredLogger.Error("Failed to stat redirect mapping file", "file", redirectMappingPath, "error", err)
+		configReloadTotal.WithLabelValues(redirectMappingPath, "error").Inc()
 		return
 	}
 	if fileInfo.Size() == 0 {
-		log.Println("Redirect mapping file is empty, skipping decode.")
+		structuredLogger.Info("Redirect mapping file is empty, skipping decode", "file", redirectMappingPath)
+		configReloadTotal.WithLabelValues(redirectMappingPath, "skipped").Inc()
 		return
 	}
 
+	raw, err := io.ReadAll(file)
+	if err != nil {
+		structuredLogger.Error("Failed to read redirect mapping file", "file", redirectMappingPath, "error", err)
+		configReloadTotal.WithLabelValues(redirectMappingPath, "error").Inc()
+		return
+	}
+
+	rules, err := decodeRedirectMapping(raw)
+	if err != nil {
+		structuredLogger.Error("Failed to decode redirect mapping file", "file", redirectMappingPath, "error", err)
+		configReloadTotal.WithLabelValues(redirectMappingPath, "error").Inc()
+		return
+	}
+
+	redirectLock.Lock()
+	redirectRules = rules
+	redirectLock.Unlock()
+
+	mappingSize.WithLabelValues(redirectMappingPath).Set(float64(len(rules)))
+	configReloadDuration.WithLabelValues(redirectMappingPath).Observe(time.Since(start).Seconds())
+	configReloadTotal.WithLabelValues(redirectMappingPath, "success").Inc()
+	structuredLogger.Info("Redirect mapping reloaded successfully", "file", redirectMappingPath, "rules", len(rules))
+}
+
+// decodeRedirectMapping accepts any of three shapes of redirect_mapping.json:
+//
+//   - a bare ordered array of rule objects: "[{...}, {...}]"
+//   - the same array wrapped in a document that also carries rate-limit
+//     defaults: {"defaults": {...}, "rules": [{...}, {...}]}
+//   - the legacy flat "*:PORT": target map, which gets promoted to Host "*"
+//     rules (order among promoted entries doesn't matter — they can never
+//     overlap on port); this shape may still carry the reserved
+//     redirectDefaultsKey entry for rate-limit defaults
+//
+// The wrapped-document form is how the array format expresses
+// _ratelimit_defaults, since a bare array has nowhere else to put it.
+func decodeRedirectMapping(raw []byte) ([]Rule, error) {
+	trimmed := strings.TrimSpace(string(raw))
+
+	var pending []Rule
+	switch {
+	case strings.HasPrefix(trimmed, "["):
+		rawRules, err := unmarshalRuleArray(raw)
+		if err != nil {
+			return nil, err
+		}
+		pending = decodeRuleObjects(rawRules)
+	case looksLikeRuleDocument(raw):
+		var doc struct {
+			Defaults json.RawMessage   `json:"defaults"`
+			Rules    []json.RawMessage `json:"rules"`
+		}
+		if err := json.Unmarshal(raw, &doc); err != nil {
+			return nil, err
+		}
+		if len(doc.Defaults) > 0 {
+			applyRateLimitDefaults(doc.Defaults)
+		}
+		pending = decodeRuleObjects(doc.Rules)
+	default:
+		var flat map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &flat); err != nil {
+			return nil, err
+		}
+		for key, msg := range flat {
+			if key == redirectDefaultsKey {
+				applyRateLimitDefaults(msg)
+				continue
+			}
+
+			rule, err := promoteLegacyRule(key, msg)
+			if err != nil {
+				structuredLogger.Warn("Skipping invalid redirect rule", "key", key, "error", err)
+				continue
+			}
+			pending = append(pending, rule)
+		}
+	}
+
+	rules := make([]Rule, 0, len(pending))
+	for _, rule := range pending {
+		if err := rule.compile(); err != nil {
+			structuredLogger.Warn("Skipping rule with invalid pattern", "host", rule.Host, "path", rule.Path, "error", err)
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// looksLikeRuleDocument reports whether raw is a JSON object carrying a
+// top-level "rules" array, i.e. the wrapped form of the array schema that
+// also has room for a "defaults" block.
+func looksLikeRuleDocument(raw []byte) bool {
+	var probe map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &probe); err != nil {
+		return false
+	}
+	rulesRaw, ok := probe["rules"]
+	if !ok {
+		return false
+	}
+	return strings.HasPrefix(strings.TrimSpace(string(rulesRaw)), "[")
+}
+
+// unmarshalRuleArray decodes a bare JSON array of rule objects.
+func unmarshalRuleArray(raw []byte) ([]json.RawMessage, error) {
+	var rawRules []json.RawMessage
+	if err := json.Unmarshal(raw, &rawRules); err != nil {
+		return nil, err
+	}
+	return rawRules, nil
+}
+
+// decodeRuleObjects parses each element of rawRules via parseRuleObject,
+// skipping (and logging) any that fail to decode.
+func decodeRuleObjects(rawRules []json.RawMessage) []Rule {
+	pending := make([]Rule, 0, len(rawRules))
+	for i, msg := range rawRules {
+		rule, err := parseRuleObject(msg)
+		if err != nil {
+			structuredLogger.Warn("Skipping invalid redirect rule", "index", i, "error", err)
+			continue
+		}
+		pending = append(pending, rule)
+	}
+	return pending
+}
+
+// applyRateLimitDefaults decodes msg as a RateLimitConfig and installs it as
+// rateLimitDefaults, used by both the legacy redirectDefaultsKey entry and
+// the wrapped array document's "defaults" field.
+func applyRateLimitDefaults(msg json.RawMessage) {
+	var defaults RateLimitConfig
+	if err := json.Unmarshal(msg, &defaults); err != nil {
+		structuredLogger.Warn("Skipping invalid rate limit defaults", "error", err)
+		return
+	}
+	rateLimitDefaultsLock.Lock()
+	rateLimitDefaults = defaults
+	rateLimitDefaultsLock.Unlock()
+}
+
+// 加载 STUN 配置
+func loadStunConfig() {
+	// 计算当前文件内容的哈希值
+	currentHash, err := getFileHash(stunConfigPath)
+	if err != nil {
+		log.Printf("Failed to calculate file hash: %v", err)
+		return
+	}
+
+	// 如果哈希值没有变化，则跳过重新加载
+	if currentHash == lastStunConfigHash {
+		return
+	}
+
+	// 更新哈希值
+	lastStunConfigHash = currentHash
+
+	file, err := os.Open(stunConfigPath)
+	if err != nil {
+		log.Printf("Failed to open STUN config file: %v", err)
+		return
+	}
+	defer file.Close()
+
+	// 检查文件大小，避免解码空文件
+	fileInfo, err := file.Stat()
+	if err != nil {
+		log.Printf("Failed to stat STUN config file: %v", err)
+		return
+	}
+	if fileInfo.Size() == 0 {
+		log.Println("STUN config file is empty, skipping decode.")
+		return
+	}
+
+	var cfg StunConfig
 	decoder := json.NewDecoder(file)
-	if err := decoder.Decode(&redirectMapping); err != nil {
-		log.Printf("Failed to decode redirect mapping file: %v", err)
+	if err := decoder.Decode(&cfg); err != nil {
+		log.Printf("Failed to decode STUN config file: %v", err)
+		return
+	}
+
+	stunConfigLock.Lock()
+	stunConfig = cfg
+	stunConfigLock.Unlock()
+
+	log.Println("STUN config reloaded successfully.")
+}
+
+// runStunLoop 周期性地向配置的 STUN 服务器发送 Binding Request，
+// 探测失败时按指数退避重试，成功后按 RefreshIntervalSec 休眠。
+func runStunLoop() {
+	backoff := defaultStunInitialBackoff
+
+	for {
+		stunConfigLock.RLock()
+		cfg := stunConfig
+		stunConfigLock.RUnlock()
+
+		if len(cfg.Servers) == 0 {
+			time.Sleep(defaultStunRefreshInterval)
+			continue
+		}
+
+		timeout := defaultStunTimeout
+		if cfg.TimeoutSec > 0 {
+			timeout = time.Duration(cfg.TimeoutSec) * time.Second
+		}
+
+		client := stun.NewClient(timeout)
+		result, err := client.Discover(cfg.Servers)
+		if err != nil {
+			log.Printf("WARN: STUN discovery failed: %v", err)
+
+			stunStatusLock.Lock()
+			stunStatus.LastError = err.Error()
+			stunStatusLock.Unlock()
+
+			maxBackoff := defaultStunMaxBackoff
+			if cfg.MaxBackoffSec > 0 {
+				maxBackoff = time.Duration(cfg.MaxBackoffSec) * time.Second
+			}
+			time.Sleep(backoff)
+			if backoff *= 2; backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+			continue
+		}
+
+		backoff = defaultStunInitialBackoff
+		if cfg.InitialBackoffSec > 0 {
+			backoff = time.Duration(cfg.InitialBackoffSec) * time.Second
+		}
+		applyStunResult(result)
+
+		refreshInterval := defaultStunRefreshInterval
+		if cfg.RefreshIntervalSec > 0 {
+			refreshInterval = time.Duration(cfg.RefreshIntervalSec) * time.Second
+		}
+		time.Sleep(refreshInterval)
+	}
+}
+
+// applyStunResult 将一次成功的 STUN 探测结果写入 dataCache，并在外部端口
+// 发生变化时持久化到 data.json、重建 redirectRules 中对应的 Host=="*" 规则。
+func applyStunResult(result *stun.Result) {
+	stunStatusLock.Lock()
+	stunStatus.LastAddr = result.Addr.IP.String()
+	stunStatus.LastPort = result.Addr.Port
+	stunStatus.LastServer = result.Server
+	stunStatus.LastRTTMillis = result.RTT.Milliseconds()
+	stunStatus.LastError = ""
+	stunStatusLock.Unlock()
+
+	dataCacheLock.Lock()
+	previousPort, _ := dataCache["port"].(float64)
+	changed := int(previousPort) != result.Addr.Port
+	dataCache["port"] = float64(result.Addr.Port)
+	dataCacheLock.Unlock()
+
+	if !changed {
 		return
 	}
 
-	log.Println("Redirect mapping reloaded successfully.")
+	stunStatusLock.Lock()
+	stunStatus.LastChangeTime = time.Now()
+	stunStatusLock.Unlock()
+
+	log.Printf("INFO: STUN discovered new external port %d via %s (rtt=%s)", result.Addr.Port, result.Server, result.RTT)
+
+	saveCacheToFile()
+	rebuildRedirectMappingForPort(result.Addr.Port)
+}
+
+// seedStunBoundPort 用 data.json 中已持久化的 port 初始化 stunBoundPort，
+// 这样如果重启后第一轮 STUN 探测结果和 data.json 一致（没有 changed），
+// rebuildRedirectMappingForPort 仍然知道"旧端口"是什么，不会在下一次真正
+// 的端口变化时因为 stunBoundPort 还是 0 而漏迁移规则。
+func seedStunBoundPort() {
+	dataCacheLock.RLock()
+	portValue, ok := dataCache["port"].(float64)
+	dataCacheLock.RUnlock()
+	if !ok {
+		return
+	}
+
+	redirectLock.Lock()
+	stunBoundPort = int(portValue)
+	redirectLock.Unlock()
+}
+
+// rebuildRedirectMappingForPort 把先前绑定到 stunBoundPort 的 "*:<port>" 规则
+// 迁移到新发现的端口上，使 redirectHandler 在端口变化后仍能命中规则。
+func rebuildRedirectMappingForPort(newPort int) {
+	redirectLock.Lock()
+	defer redirectLock.Unlock()
+
+	newPortStr := fmt.Sprintf("%d", newPort)
+
+	if stunBoundPort != 0 {
+		oldPortStr := fmt.Sprintf("%d", stunBoundPort)
+		for i := range redirectRules {
+			if redirectRules[i].Host == "*" && redirectRules[i].Port == oldPortStr {
+				redirectRules[i].Port = newPortStr
+				if err := redirectRules[i].compile(); err != nil {
+					structuredLogger.Warn("Failed to recompile rule after STUN port change", "error", err)
+				}
+			}
+		}
+	}
+
+	stunBoundPort = newPort
+}
+
+// stunStatusHandler 返回最近一次 STUN 探测的状态
+func stunStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	stunStatusLock.RLock()
+	defer stunStatusLock.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stunStatus)
 }
 
 // 监听文件变化
@@ -202,6 +632,11 @@ func watchFiles(filename string, reloadFunc func()) {
 		log.Fatalf("Failed to add file to watcher: %v", err)
 	}
 
+	// 每个被监听的文件各自持有一个去抖动定时器，避免与其他 watchFiles
+	// goroutine 共享同一个 timer——共享会导致先到的文件变更在去抖动窗口内
+	// 被后到的文件变更整体替换掉，从而永远不会触发重新加载。
+	var debounceTimer *time.Timer
+
 	for {
 		select {
 		case event, ok := <-watcher.Events:
@@ -226,11 +661,12 @@ func watchFiles(filename string, reloadFunc func()) {
 	}
 }
 
-// 认证中间件
+// 认证中间件。validBearerToken 为空（BEARER_TOKEN 未设置）时一律拒绝，
+// 避免空 Authorization 头恰好与空 token 比较相等而被放行。
 func authenticate(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
+		if authHeader == "" || validBearerToken == "" {
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
@@ -245,6 +681,133 @@ func authenticate(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+// rateLimitAPI 用 _ratelimit_defaults 中配置的全局限流网关前置 API 接口，
+// 未配置（RPS<=0）时直接放行。
+func rateLimitAPI(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cfg := effectiveRateLimitConfig(Rule{})
+		if cfg.RPS <= 0 {
+			next(w, r)
+			return
+		}
+
+		key := fmt.Sprintf("api:%s", r.URL.Path)
+		if cfg.Per != "global" {
+			key = fmt.Sprintf("%s|%s", key, clientIP(r))
+		}
+
+		if !redirectLimiterStore.Allow(key, cfg) {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// clientIP extracts the caller's IP from RemoteAddr, falling back to the raw
+// value when it isn't a host:port pair.
+func clientIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// effectiveRateLimitConfig 把规则上显式配置的限流参数和全局默认值合并：
+// 规则未设置（<=0 或空字符串）的字段回退到 rateLimitDefaults。
+func effectiveRateLimitConfig(rule Rule) ratelimit.Config {
+	rateLimitDefaultsLock.RLock()
+	defaults := rateLimitDefaults
+	rateLimitDefaultsLock.RUnlock()
+
+	rps := rule.RPS
+	if rps <= 0 {
+		rps = defaults.RPS
+	}
+	burst := rule.Burst
+	if burst <= 0 {
+		burst = defaults.Burst
+	}
+	per := rule.Per
+	if per == "" {
+		per = defaults.Per
+	}
+	if per == "" {
+		per = "ip"
+	}
+
+	return ratelimit.Config{RPS: rps, Burst: burst, Per: per}
+}
+
+// allowRedirectRequest 按 ruleKey 对应的限流配置（规则自身或默认值）判断是
+// 否放行，未配置限流（RPS<=0）时始终放行。
+func allowRedirectRequest(ruleKey string, rule Rule, r *http.Request) bool {
+	cfg := effectiveRateLimitConfig(rule)
+	if cfg.RPS <= 0 {
+		return true
+	}
+
+	key := ruleKey
+	if cfg.Per != "global" {
+		key = fmt.Sprintf("%s|%s", ruleKey, clientIP(r))
+	}
+
+	return redirectLimiterStore.Allow(key, cfg)
+}
+
+// ratelimitStatusHandler 返回当前所有活跃限流器的状态
+func ratelimitStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(redirectLimiterStore.Snapshot())
+}
+
+// mappingTestHandler 在不实际转发请求的情况下，回答"给定 host/port/method/
+// path 会命中哪条规则"，便于在改动 redirect_mapping.json 前先核对规则顺序。
+func mappingTestHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	host := query.Get("host")
+	port := query.Get("port")
+	path := query.Get("path")
+	if path == "" {
+		path = "/"
+	}
+	method := query.Get("method")
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	redirectLock.RLock()
+	rule, ok := matchRedirectRule(host, port, method, path)
+	redirectLock.RUnlock()
+
+	result := struct {
+		Matched bool   `json:"matched"`
+		Rule    string `json:"rule,omitempty"`
+		Target  string `json:"target,omitempty"`
+		Mode    string `json:"mode,omitempty"`
+	}{Matched: ok}
+	if ok {
+		result.Rule = rule.Key
+		result.Target = rule.Target
+		result.Mode = rule.Mode
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
 // 保存数据接口
 func saveDataHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -298,18 +861,31 @@ func redirectHandler(w http.ResponseWriter, r *http.Request) {
 	redirectLock.RLock()
 	defer redirectLock.RUnlock()
 
-	log.Printf("INFO: Received request: Host=%s, URL=%s, RemoteAddr=%s", r.Host, r.URL.String(), r.RemoteAddr)
+	start := time.Now()
+	var port, matchedRule, outcome string
+
+	defer func() {
+		latencyPort := port
+		if latencyPort == "" {
+			latencyPort = "unknown"
+		}
+		redirectHandlerLatency.WithLabelValues(latencyPort).Observe(time.Since(start).Seconds())
+		redirectRequestsTotal.WithLabelValues(latencyPort, matchedRule, outcome).Inc()
+	}()
+
+	structuredLogger.Info("Received request", "host", r.Host, "url", r.URL.String(), "remote_addr", r.RemoteAddr)
 
-	// 提取请求的端口号
-	host := r.Host
-	var port string
+	// 提取请求的主机名和端口号
+	rawHost := r.Host
+	host := rawHost
 	var err error
 
 	// 检查 Host 是否包含端口号
 	if strings.Contains(host, ":") {
-		_, port, err = net.SplitHostPort(host)
+		host, port, err = net.SplitHostPort(host)
 		if err != nil {
-			log.Printf("ERROR: Failed to extract port from host: %v", err)
+			structuredLogger.Error("Failed to extract port from host", "host", rawHost, "remote_addr", r.RemoteAddr, "error", err)
+			outcome = "invalid_host"
 			http.Error(w, "Invalid Host", http.StatusBadRequest)
 			return
 		}
@@ -320,7 +896,8 @@ func redirectHandler(w http.ResponseWriter, r *http.Request) {
 		dataCacheLock.RUnlock()
 
 		if !ok {
-			log.Printf("ERROR: 'port' key not found in dataCache")
+			structuredLogger.Error("'port' key not found in dataCache", "remote_addr", r.RemoteAddr)
+			outcome = "internal_error"
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
@@ -328,7 +905,8 @@ func redirectHandler(w http.ResponseWriter, r *http.Request) {
 		// 检查 portValue 是否为 float64 类型
 		portFloat, ok := portValue.(float64)
 		if !ok {
-			log.Printf("ERROR: 'port' value is not a float64: %v", portValue)
+			structuredLogger.Error("'port' value is not a float64", "value", portValue, "remote_addr", r.RemoteAddr)
+			outcome = "internal_error"
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
@@ -336,44 +914,64 @@ func redirectHandler(w http.ResponseWriter, r *http.Request) {
 		port = fmt.Sprintf("%d", int(portFloat))
 	}
 
-	// 构建重定向规则的键
-	key := fmt.Sprintf("*:%s", port)
+	structuredLogger.Info("Looking up redirect rule", "host", host, "port", port, "path", r.URL.Path, "method", r.Method, "remote_addr", r.RemoteAddr)
 
-	log.Printf("INFO: Looking up redirect rule for key: %s", key) // 打印查找的键
+	// 按 host/port/method/path 在 redirectRules 中找第一条匹配的规则
+	rule, ok := matchRedirectRule(host, port, r.Method, r.URL.Path)
+	if !ok {
+		structuredLogger.Warn("No redirect rule found", "host", host, "port", port, "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+		redirectLookupMissTotal.WithLabelValues(port).Inc()
+		outcome = "not_found"
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	matchedRule = rule.Key
 
-	// 从内存中的 redirectMapping 查找重定向规则
-	if newURL, ok := redirectMapping[key]; ok {
-		// 如果 URL 不包含协议，默认添加 http://
-		if !strings.HasPrefix(newURL, "http") {
-			newURL = fmt.Sprintf("http://%s", newURL)
-		}
+	if !allowRedirectRequest(rule.Key, rule, r) {
+		structuredLogger.Warn("Rate limit exceeded", "key", rule.Key, "remote_addr", r.RemoteAddr)
+		outcome = "rate_limited"
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+		return
+	}
 
-		// 直接将 data.json 中的端口号附加到目标 URL 后面
-		dataCacheLock.RLock()
-		portValue, ok := dataCache["port"]
-		dataCacheLock.RUnlock()
+	if rule.Mode == redirectModeProxy || rule.Mode == redirectModeProxyWS {
+		outcome = rule.Mode
+		handleProxy(w, r, rule, rule.Key)
+		return
+	}
 
-		if !ok {
-			log.Printf("ERROR: 'port' key not found in dataCache")
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-			return
-		}
+	newURL := rule.Target
+	// 如果 URL 不包含协议，默认添加 http://
+	if !strings.HasPrefix(newURL, "http") {
+		newURL = fmt.Sprintf("http://%s", newURL)
+	}
 
-		portFloat, ok := portValue.(float64)
-		if !ok {
-			log.Printf("ERROR: 'port' value is not a float64: %v", portValue)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-			return
-		}
+	// 直接将 data.json 中的端口号附加到目标 URL 后面
+	dataCacheLock.RLock()
+	portValue, ok := dataCache["port"]
+	dataCacheLock.RUnlock()
 
-		newURL = fmt.Sprintf("%s:%d", strings.TrimRight(newURL, "/"), int(portFloat))
+	if !ok {
+		structuredLogger.Error("'port' key not found in dataCache", "key", rule.Key, "remote_addr", r.RemoteAddr)
+		outcome = "internal_error"
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
 
-		log.Printf("INFO: Redirecting to: %s", newURL)
-		http.Redirect(w, r, newURL, http.StatusFound)
-	} else {
-		log.Printf("WARN: No redirect rule found for %s", key)
-		http.Error(w, "Not Found", http.StatusNotFound)
+	portFloat, ok := portValue.(float64)
+	if !ok {
+		structuredLogger.Error("'port' value is not a float64", "value", portValue, "key", rule.Key, "remote_addr", r.RemoteAddr)
+		outcome = "internal_error"
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
 	}
+
+	newURL = fmt.Sprintf("%s:%d", strings.TrimRight(newURL, "/"), int(portFloat))
+
+	structuredLogger.Info("Redirecting", "key", rule.Key, "target", newURL, "matched_rule", rule.Key, "remote_addr", r.RemoteAddr)
+	outcome = "redirect"
+	http.Redirect(w, r, newURL, http.StatusFound)
 }
 
 // 启动 HTTP 服务器
@@ -411,9 +1009,19 @@ func startHTTPServers(ports []string) {
 }
 
 func main() {
+	// 检查环境变量是否设置。放在 main 而不是 init 里，这样 go test 不需要
+	// 生产环境的 BEARER_TOKEN 就能跑起来。
+	if validBearerToken == "" {
+		log.Fatalf("BEARER_TOKEN environment variable is not set")
+	}
+
 	// 设置 API 路由
-	http.HandleFunc("/api/save", authenticate(saveDataHandler))
-	http.HandleFunc("/api/get", authenticate(getDataHandler))
+	http.HandleFunc("/api/save", rateLimitAPI(authenticate(saveDataHandler)))
+	http.HandleFunc("/api/get", rateLimitAPI(authenticate(getDataHandler)))
+	http.HandleFunc("/api/stun/status", authenticate(stunStatusHandler))
+	http.HandleFunc("/api/ratelimit/status", authenticate(ratelimitStatusHandler))
+	http.HandleFunc("/api/mapping/test", authenticate(mappingTestHandler))
+	http.Handle("/metrics", promhttp.Handler())
 
 	// 启动 API 服务器，监听 5000 端口
 	go func() {
@@ -423,21 +1031,31 @@ func main() {
 		}
 	}()
 
-	// 从 redirect_mapping.json 中提取需要监听的端口
+	// 从 redirectRules 中提取需要监听的具体端口（跳过留空或通配的端口）
 	var ports []string
+	seenPorts := make(map[string]bool)
 	redirectLock.RLock()
-	for key := range redirectMapping {
-		_, port, err := net.SplitHostPort(key)
-		if err != nil {
-			log.Printf("WARN: Invalid key in redirect_mapping.json: %s", key)
+	for _, rule := range redirectRules {
+		if rule.Port == "" || rule.Port == "*" || seenPorts[rule.Port] {
 			continue
 		}
-		ports = append(ports, port)
+		seenPorts[rule.Port] = true
+		ports = append(ports, rule.Port)
 	}
 	redirectLock.RUnlock()
 
+	// listeners.json 中已配置的端口（任意协议，包括 http）由 reconcileListeners
+	// 管理，这里只需为剩下的端口兜底启动纯 HTTP 服务器
+	configured := configuredListenerPorts()
+	var legacyPorts []string
+	for _, port := range ports {
+		if !configured[port] {
+			legacyPorts = append(legacyPorts, port)
+		}
+	}
+
 	// 启动 HTTP 服务器
-	startHTTPServers(ports)
+	startHTTPServers(legacyPorts)
 
 	// 保持主程序运行
 	select {}
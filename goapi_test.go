@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/yang478/STUN-Port-Redirector/stun"
+)
+
+// resetStunState saves and restores dataCache, redirectRules and
+// stunBoundPort, the package-level state applyStunResult/seedStunBoundPort/
+// rebuildRedirectMappingForPort mutate that these tests assert on, so tests
+// can run in isolation and in any order. stunStatus is not restored.
+func resetStunState(t *testing.T) {
+	t.Helper()
+	origData := dataCache
+	origRules := redirectRules
+	origBoundPort := stunBoundPort
+	t.Cleanup(func() {
+		dataCache = origData
+		redirectRules = origRules
+		stunBoundPort = origBoundPort
+	})
+	dataCache = make(map[string]interface{})
+}
+
+func TestSeedStunBoundPort_FromPersistedPort(t *testing.T) {
+	resetStunState(t)
+
+	dataCache["port"] = float64(8080)
+	stunBoundPort = 0
+
+	seedStunBoundPort()
+
+	if stunBoundPort != 8080 {
+		t.Errorf("stunBoundPort = %d, want 8080", stunBoundPort)
+	}
+}
+
+func TestApplyStunResult_MigratesRuleAfterNoopRoundFollowedByRealChange(t *testing.T) {
+	resetStunState(t)
+
+	// Steady state after a restart: data.json already has the last known
+	// port, and there's a rule bound to it.
+	dataCache["port"] = float64(8080)
+	redirectRules = []Rule{compileRule(t, Rule{Host: "*", Port: "8080", Target: "backend:8080"})}
+	seedStunBoundPort()
+
+	// First STUN round after startup reports the same port: a no-op.
+	applyStunResult(&stun.Result{Addr: stun.MappedAddress{IP: net.ParseIP("203.0.113.1"), Port: 8080}, Server: "stun1", RTT: time.Millisecond})
+
+	if _, ok := matchRedirectRule("*", "8080", "GET", "/"); !ok {
+		t.Fatal("rule for the unchanged port should still match")
+	}
+
+	// A later round reports a genuine port change.
+	applyStunResult(&stun.Result{Addr: stun.MappedAddress{IP: net.ParseIP("203.0.113.1"), Port: 9000}, Server: "stun1", RTT: time.Millisecond})
+
+	if _, ok := matchRedirectRule("*", "9000", "GET", "/"); !ok {
+		t.Error("rule should have been migrated to the new port, but no rule matches it")
+	}
+	if _, ok := matchRedirectRule("*", "8080", "GET", "/"); ok {
+		t.Error("old port's rule should have been migrated away, not left behind")
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+const (
+	listenersConfigPath   = "/app/listeners.json"
+	defaultAutocertDir    = "/app/autocert-cache"
+	listenerShutdownGrace = 5 * time.Second
+)
+
+// 监听器支持的协议
+const (
+	protoHTTP  = "http"
+	protoHTTPS = "https"
+	protoH2    = "h2"  // HTTP/2 over TLS
+	protoH2C   = "h2c" // HTTP/2 明文
+)
+
+// ListenerConfig 描述一个端口上要启动的监听器。Protocol 为空时等价于 "http"。
+type ListenerConfig struct {
+	Port     string   `json:"port"`
+	Protocol string   `json:"protocol"`
+	CertFile string   `json:"cert_file,omitempty"`
+	KeyFile  string   `json:"key_file,omitempty"`
+	AutoCert bool     `json:"autocert,omitempty"`
+	CacheDir string   `json:"autocert_cache_dir,omitempty"`
+	Domains  []string `json:"domains,omitempty"`
+}
+
+// runningListener 跟踪某个端口当前启动时所用的配置，便于在配置变化时判断
+// 是否需要重启。
+type runningListener struct {
+	server *http.Server
+	config ListenerConfig
+}
+
+var (
+	listenersConfig     []ListenerConfig
+	listenersConfigLock sync.RWMutex
+	lastListenersHash   string
+
+	runningListeners     = make(map[string]*runningListener)
+	runningListenersLock sync.Mutex
+)
+
+// loadListenersConfig 加载 listeners.json 并让运行中的监听器与其保持一致。
+func loadListenersConfig() {
+	// 计算当前文件内容的哈希值
+	currentHash, err := getFileHash(listenersConfigPath)
+	if err != nil {
+		log.Printf("Failed to calculate file hash: %v", err)
+		return
+	}
+
+	// 如果哈希值没有变化，则跳过重新加载
+	if currentHash == lastListenersHash {
+		return
+	}
+
+	// 更新哈希值
+	lastListenersHash = currentHash
+
+	file, err := os.Open(listenersConfigPath)
+	if err != nil {
+		log.Printf("Failed to open listeners config file: %v", err)
+		return
+	}
+	defer file.Close()
+
+	// 检查文件大小，避免解码空文件
+	fileInfo, err := file.Stat()
+	if err != nil {
+		log.Printf("Failed to stat listeners config file: %v", err)
+		return
+	}
+	if fileInfo.Size() == 0 {
+		log.Println("Listeners config file is empty, skipping decode.")
+		return
+	}
+
+	var cfg []ListenerConfig
+	decoder := json.NewDecoder(file)
+	if err := decoder.Decode(&cfg); err != nil {
+		log.Printf("Failed to decode listeners config file: %v", err)
+		return
+	}
+
+	listenersConfigLock.Lock()
+	listenersConfig = cfg
+	listenersConfigLock.Unlock()
+
+	log.Println("Listeners config reloaded successfully.")
+	reconcileListeners(cfg)
+}
+
+// reconcileListeners 启动配置中新增/变化的监听器，并优雅停止被移除的监听器。
+func reconcileListeners(desired []ListenerConfig) {
+	desiredByPort := make(map[string]ListenerConfig, len(desired))
+	for _, lc := range desired {
+		desiredByPort[lc.Port] = lc
+	}
+
+	runningListenersLock.Lock()
+	defer runningListenersLock.Unlock()
+
+	for port, rl := range runningListeners {
+		if _, ok := desiredByPort[port]; !ok {
+			stopListenerLocked(port, rl)
+		}
+	}
+
+	for port, lc := range desiredByPort {
+		if existing, ok := runningListeners[port]; ok {
+			if reflect.DeepEqual(existing.config, lc) {
+				continue
+			}
+			stopListenerLocked(port, existing)
+		}
+		startListenerLocked(port, lc)
+	}
+}
+
+// stopListenerLocked 优雅关闭一个监听器；调用方必须持有 runningListenersLock。
+func stopListenerLocked(port string, rl *runningListener) {
+	log.Printf("Stopping listener on port %s...", port)
+
+	ctx, cancel := context.WithTimeout(context.Background(), listenerShutdownGrace)
+	defer cancel()
+
+	if err := rl.server.Shutdown(ctx); err != nil {
+		log.Printf("ERROR: Graceful shutdown of listener on port %s failed: %v", port, err)
+	}
+
+	delete(runningListeners, port)
+}
+
+// startListenerLocked 按配置启动一个监听器；调用方必须持有 runningListenersLock。
+func startListenerLocked(port string, lc ListenerConfig) {
+	server := &http.Server{Addr: ":" + port}
+
+	switch lc.Protocol {
+	case "", protoHTTP:
+		server.Handler = http.HandlerFunc(redirectHandler)
+	case protoH2C:
+		server.Handler = h2c.NewHandler(http.HandlerFunc(redirectHandler), &http2.Server{})
+	case protoHTTPS, protoH2:
+		server.Handler = http.HandlerFunc(redirectHandler)
+		server.TLSConfig = buildTLSConfig(lc)
+		if lc.Protocol == protoH2 {
+			if err := http2.ConfigureServer(server, &http2.Server{}); err != nil {
+				log.Printf("ERROR: Failed to configure HTTP/2 for listener on port %s: %v", port, err)
+			}
+		}
+	default:
+		log.Printf("WARN: Unknown listener protocol %q for port %s, defaulting to http", lc.Protocol, port)
+		server.Handler = http.HandlerFunc(redirectHandler)
+	}
+
+	ln, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		log.Printf("ERROR: Failed to listen on port %s: %v", port, err)
+		return
+	}
+
+	runningListeners[port] = &runningListener{server: server, config: lc}
+
+	go func() {
+		var serveErr error
+		switch lc.Protocol {
+		case protoHTTPS, protoH2:
+			if lc.AutoCert {
+				serveErr = server.ServeTLS(ln, "", "")
+			} else {
+				serveErr = server.ServeTLS(ln, lc.CertFile, lc.KeyFile)
+			}
+		default:
+			serveErr = server.Serve(ln)
+		}
+		if serveErr != nil && serveErr != http.ErrServerClosed {
+			log.Printf("ERROR: Listener on port %s stopped: %v", port, serveErr)
+		}
+	}()
+
+	log.Printf("Listener started on port %s (protocol=%s)", port, lc.Protocol)
+}
+
+// buildTLSConfig returns an empty TLS config (the static cert/key are loaded
+// by ServeTLS from CertFile/KeyFile), or an ACME-backed one when AutoCert is
+// set.
+func buildTLSConfig(lc ListenerConfig) *tls.Config {
+	if !lc.AutoCert {
+		return &tls.Config{}
+	}
+
+	cacheDir := lc.CacheDir
+	if cacheDir == "" {
+		cacheDir = defaultAutocertDir
+	}
+
+	manager := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(lc.Domains...),
+		Cache:      autocert.DirCache(cacheDir),
+	}
+	return manager.TLSConfig()
+}
+
+// configuredListenerPorts 返回 listeners.json 中已经显式配置的端口集合,
+// 供 main() 判断哪些 redirect_mapping 端口仍需走旧的纯 HTTP 兜底路径。
+func configuredListenerPorts() map[string]bool {
+	listenersConfigLock.RLock()
+	defer listenersConfigLock.RUnlock()
+
+	ports := make(map[string]bool, len(listenersConfig))
+	for _, lc := range listenersConfig {
+		ports[lc.Port] = true
+	}
+	return ports
+}
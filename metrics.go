@@ -0,0 +1,41 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Prometheus 指标，供 /metrics 端点导出。
+var (
+	redirectRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "redirect_requests_total",
+		Help: "Total number of requests handled by redirectHandler, by port, matched rule key and outcome.",
+	}, []string{"port", "rule", "outcome"})
+
+	redirectLookupMissTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "redirect_lookup_miss_total",
+		Help: "Total number of requests for which no redirect rule matched, by port.",
+	}, []string{"port"})
+
+	configReloadTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "config_reload_total",
+		Help: "Total number of config file reload/write attempts, by file and result.",
+	}, []string{"file", "result"})
+
+	configReloadDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "config_reload_duration_seconds",
+		Help:    "Time spent loading or writing a config file.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"file"})
+
+	mappingSize = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "mapping_size",
+		Help: "Number of entries currently held in an in-memory config map, by file.",
+	}, []string{"file"})
+
+	redirectHandlerLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "redirect_handler_duration_seconds",
+		Help:    "Latency of redirectHandler, by port.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"port"})
+)
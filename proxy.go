@@ -0,0 +1,234 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	backendBackoffStep = 1 * time.Second
+	backendBackoffMax  = 30 * time.Second
+)
+
+// backendBackoff tracks consecutive 5xx/unreachable responses from a rule's
+// backend, so repeated failures can be shed with a 503 instead of retried on
+// every request.
+type backendBackoff struct {
+	mu             sync.Mutex
+	consecutive5xx int
+	until          time.Time
+}
+
+func (b *backendBackoff) active() (time.Duration, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if remaining := time.Until(b.until); remaining > 0 {
+		return remaining, true
+	}
+	return 0, false
+}
+
+func (b *backendBackoff) recordFailure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.consecutive5xx++
+	backoff := time.Duration(b.consecutive5xx) * backendBackoffStep
+	if backoff > backendBackoffMax {
+		backoff = backendBackoffMax
+	}
+	b.until = time.Now().Add(backoff)
+}
+
+func (b *backendBackoff) recordSuccess() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.consecutive5xx = 0
+	b.until = time.Time{}
+}
+
+var (
+	backendBackoffStates     = make(map[string]*backendBackoff)
+	backendBackoffStatesLock sync.Mutex
+)
+
+// backoffFor returns the shared backendBackoff for ruleKey, creating it on
+// first use.
+func backoffFor(ruleKey string) *backendBackoff {
+	backendBackoffStatesLock.Lock()
+	defer backendBackoffStatesLock.Unlock()
+	if b, ok := backendBackoffStates[ruleKey]; ok {
+		return b
+	}
+	b := &backendBackoff{}
+	backendBackoffStates[ruleKey] = b
+	return b
+}
+
+// proxyTransport 是所有 proxy / proxy+ws 规则共用的连接池化 Transport。
+var proxyTransport = &http.Transport{
+	Proxy: http.ProxyFromEnvironment,
+	DialContext: (&net.Dialer{
+		Timeout:   10 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}).DialContext,
+	MaxIdleConns:          100,
+	MaxIdleConnsPerHost:   20,
+	IdleConnTimeout:       90 * time.Second,
+	TLSHandshakeTimeout:   10 * time.Second,
+	ExpectContinueTimeout: 1 * time.Second,
+}
+
+// handleProxy 按规则的 Mode 把请求转发给后端：proxy+ws 在收到 WebSocket
+// 升级请求时走 hijack 隧道，其余情况走标准反向代理。ruleKey 用于区分每条
+// 规则各自的后端失败退避状态。连续 5xx/拨号失败会让后续请求直接收到
+// 503 + Retry-After，直到退避窗口过去。
+func handleProxy(w http.ResponseWriter, r *http.Request, rule Rule, ruleKey string) {
+	rawTarget := rule.Target
+	// 规则里的 target 照例写成裸的 host:port（与 redirect 模式、旧版
+	// redirect_mapping.json 保持一致），url.Parse 需要一个 scheme 才能正确
+	// 把它识别成 Host 而不是 opaque 数据。
+	if !strings.HasPrefix(rawTarget, "http://") && !strings.HasPrefix(rawTarget, "https://") {
+		rawTarget = "http://" + rawTarget
+	}
+	target, err := url.Parse(rawTarget)
+	if err != nil || target.Host == "" {
+		log.Printf("ERROR: Invalid proxy target %q: %v", rule.Target, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	backoff := backoffFor(ruleKey)
+	if remaining, active := backoff.active(); active {
+		w.Header().Set("Retry-After", fmt.Sprintf("%d", int(remaining.Seconds())+1))
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	if rule.Mode == redirectModeProxyWS && isWebSocketUpgrade(r) {
+		if err := proxyWebSocket(w, r, target); err != nil {
+			log.Printf("ERROR: WebSocket proxy to %s failed: %v", target, err)
+			backoff.recordFailure()
+			http.Error(w, "Bad Gateway", http.StatusBadGateway)
+			return
+		}
+		backoff.recordSuccess()
+		return
+	}
+
+	newReverseProxy(target, backoff).ServeHTTP(w, r)
+}
+
+// isWebSocketUpgrade reports whether r is asking to upgrade to the
+// WebSocket protocol.
+func isWebSocketUpgrade(r *http.Request) bool {
+	return strings.Contains(strings.ToLower(r.Header.Get("Connection")), "upgrade") &&
+		strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
+}
+
+// newReverseProxy 构建指向 target 的反向代理，复用连接池化 Transport，补上
+// X-Forwarded-* 头，并把后端的 5xx 响应/拨号失败计入 backoff。
+func newReverseProxy(target *url.URL, backoff *backendBackoff) *httputil.ReverseProxy {
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.Transport = proxyTransport
+
+	originalDirector := proxy.Director
+	proxy.Director = func(req *http.Request) {
+		originalDirector(req)
+		setForwardedHeaders(req)
+	}
+
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		if resp.StatusCode >= http.StatusInternalServerError {
+			backoff.recordFailure()
+		} else {
+			backoff.recordSuccess()
+		}
+		return nil
+	}
+
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		log.Printf("ERROR: Proxy request to %s failed: %v", target, err)
+		backoff.recordFailure()
+		http.Error(w, "Bad Gateway", http.StatusBadGateway)
+	}
+
+	return proxy
+}
+
+// setForwardedHeaders 在转发请求上设置标准的 X-Forwarded-* 头，追加到任何
+// 已存在的 X-Forwarded-For 链上。
+func setForwardedHeaders(req *http.Request) {
+	forwardedFor := req.RemoteAddr
+	if host, _, err := net.SplitHostPort(forwardedFor); err == nil {
+		forwardedFor = host
+	}
+	if prior := req.Header.Get("X-Forwarded-For"); prior != "" {
+		forwardedFor = prior + ", " + forwardedFor
+	}
+	req.Header.Set("X-Forwarded-For", forwardedFor)
+	req.Header.Set("X-Forwarded-Host", req.Host)
+
+	proto := "http"
+	if req.TLS != nil {
+		proto = "https"
+	}
+	req.Header.Set("X-Forwarded-Proto", proto)
+}
+
+// proxyWebSocket hijacks the client connection and tunnels raw bytes
+// between it and a freshly dialed backend connection, after forwarding the
+// original request line and headers.
+func proxyWebSocket(w http.ResponseWriter, r *http.Request, target *url.URL) error {
+	backendConn, err := net.DialTimeout("tcp", target.Host, 10*time.Second)
+	if err != nil {
+		return fmt.Errorf("dial backend: %w", err)
+	}
+	defer backendConn.Close()
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return fmt.Errorf("response writer does not support hijacking")
+	}
+	clientConn, clientBuf, err := hijacker.Hijack()
+	if err != nil {
+		return fmt.Errorf("hijack client connection: %w", err)
+	}
+	defer clientConn.Close()
+
+	outReq := r.Clone(r.Context())
+	outReq.URL.Scheme = "http"
+	outReq.URL.Host = target.Host
+	outReq.RequestURI = ""
+	setForwardedHeaders(outReq)
+
+	if err := outReq.Write(backendConn); err != nil {
+		return fmt.Errorf("write request to backend: %w", err)
+	}
+
+	if buffered := clientBuf.Reader.Buffered(); buffered > 0 {
+		if _, err := io.CopyN(backendConn, clientBuf.Reader, int64(buffered)); err != nil {
+			return fmt.Errorf("flush buffered client data: %w", err)
+		}
+	}
+
+	errCh := make(chan error, 2)
+	go func() {
+		_, err := io.Copy(backendConn, clientConn)
+		errCh <- err
+	}()
+	go func() {
+		_, err := io.Copy(clientConn, backendConn)
+		errCh <- err
+	}()
+	<-errCh
+
+	return nil
+}
@@ -0,0 +1,154 @@
+// Package ratelimit provides a token-bucket rate limiter store keyed by an
+// arbitrary string (typically "<rule>|<remote_ip>" or "<rule>" for
+// globally-scoped limits), evicting idle entries after a TTL so the store
+// doesn't grow without bound under a churning set of client IPs.
+package ratelimit
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Config is the limit applied to a single key: RPS tokens replenished per
+// second, up to Burst tokens held at once. Per records the scope the key was
+// derived under ("ip" or "global") for reporting purposes only.
+type Config struct {
+	RPS   float64
+	Burst int
+	Per   string
+}
+
+type entry struct {
+	key       string
+	limiter   *rate.Limiter
+	cfg       Config
+	expiresAt time.Time
+}
+
+// Store is an LRU cache of rate.Limiter instances with TTL-based eviction.
+// Every successful lookup refreshes both recency and TTL, so an idle key
+// falls off the back of the list and out of the map once its TTL elapses.
+type Store struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+	items      map[string]*list.Element
+	order      *list.List
+}
+
+// NewStore returns a Store that evicts entries idle longer than ttl, and
+// caps itself at maxEntries (oldest evicted first) regardless of TTL.
+// maxEntries <= 0 means unbounded.
+func NewStore(ttl time.Duration, maxEntries int) *Store {
+	return &Store{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		items:      make(map[string]*list.Element),
+		order:      list.New(),
+	}
+}
+
+// Allow reports whether a request identified by key is allowed under cfg,
+// creating the backing limiter on first use and reusing it afterwards. If an
+// existing limiter's Config has changed, it is replaced (tokens reset).
+func (s *Store) Allow(key string, cfg Config) bool {
+	return s.getLimiter(key, cfg).Allow()
+}
+
+func (s *Store) getLimiter(key string, cfg Config) *rate.Limiter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.evictExpiredLocked(now)
+
+	if elem, ok := s.items[key]; ok {
+		e := elem.Value.(*entry)
+		if e.cfg != cfg {
+			e.limiter = rate.NewLimiter(rate.Limit(cfg.RPS), cfg.Burst)
+			e.cfg = cfg
+		}
+		e.expiresAt = now.Add(s.ttl)
+		s.order.MoveToFront(elem)
+		return e.limiter
+	}
+
+	e := &entry{
+		key:       key,
+		limiter:   rate.NewLimiter(rate.Limit(cfg.RPS), cfg.Burst),
+		cfg:       cfg,
+		expiresAt: now.Add(s.ttl),
+	}
+	s.items[key] = s.order.PushFront(e)
+
+	for s.maxEntries > 0 && s.order.Len() > s.maxEntries {
+		s.evictOldestLocked()
+	}
+
+	return e.limiter
+}
+
+// evictExpiredLocked drops entries from the back of the list (least
+// recently used) until the oldest remaining entry hasn't expired. Since
+// expiresAt is always "last access + ttl", recency order and expiry order
+// coincide for a fixed ttl.
+func (s *Store) evictExpiredLocked(now time.Time) {
+	for {
+		back := s.order.Back()
+		if back == nil {
+			return
+		}
+		if back.Value.(*entry).expiresAt.After(now) {
+			return
+		}
+		s.removeElementLocked(back)
+	}
+}
+
+func (s *Store) evictOldestLocked() {
+	if back := s.order.Back(); back != nil {
+		s.removeElementLocked(back)
+	}
+}
+
+func (s *Store) removeElementLocked(elem *list.Element) {
+	e := elem.Value.(*entry)
+	s.order.Remove(elem)
+	delete(s.items, e.key)
+}
+
+// Snapshot is a point-in-time view of one active limiter, for status
+// reporting.
+type Snapshot struct {
+	Key       string  `json:"key"`
+	RPS       float64 `json:"rps"`
+	Burst     int     `json:"burst"`
+	Per       string  `json:"per"`
+	Available float64 `json:"available_tokens"`
+}
+
+// Snapshot returns the current state of every non-expired limiter, most
+// recently used first.
+func (s *Store) Snapshot() []Snapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.evictExpiredLocked(now)
+
+	snapshots := make([]Snapshot, 0, s.order.Len())
+	for elem := s.order.Front(); elem != nil; elem = elem.Next() {
+		e := elem.Value.(*entry)
+		snapshots = append(snapshots, Snapshot{
+			Key:       e.key,
+			RPS:       float64(e.limiter.Limit()),
+			Burst:     e.limiter.Burst(),
+			Per:       e.cfg.Per,
+			Available: e.limiter.TokensAt(now),
+		})
+	}
+	return snapshots
+}
@@ -0,0 +1,92 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStore_AllowRespectsBurst(t *testing.T) {
+	s := NewStore(time.Minute, 0)
+	cfg := Config{RPS: 0.001, Burst: 2, Per: "ip"}
+
+	if !s.Allow("k", cfg) || !s.Allow("k", cfg) {
+		t.Fatal("first two requests within burst should be allowed")
+	}
+	if s.Allow("k", cfg) {
+		t.Error("third immediate request should exceed the burst of 2")
+	}
+}
+
+func TestStore_ZeroRPSDoesNotReplenish(t *testing.T) {
+	s := NewStore(time.Minute, 0)
+	cfg := Config{RPS: 0, Burst: 1, Per: "ip"}
+
+	if !s.Allow("k", cfg) {
+		t.Fatal("the initial burst token should still be available")
+	}
+	if s.Allow("k", cfg) {
+		t.Error("RPS=0 should never replenish the bucket after the initial burst")
+	}
+}
+
+func TestStore_ConfigChangeResetsLimiter(t *testing.T) {
+	s := NewStore(time.Minute, 0)
+	s.Allow("k", Config{RPS: 1, Burst: 1, Per: "ip"})
+
+	// Exhaust the original limiter's single token.
+	s.getLimiter("k", Config{RPS: 1, Burst: 1, Per: "ip"}).Allow()
+
+	// Changing the config for the same key should replace the limiter
+	// (and its token bucket) rather than reusing the exhausted one.
+	if !s.Allow("k", Config{RPS: 1000, Burst: 5, Per: "ip"}) {
+		t.Error("a changed Config should get a fresh limiter, not the old exhausted one")
+	}
+}
+
+func TestStore_EvictsIdleEntriesAfterTTL(t *testing.T) {
+	s := NewStore(10*time.Millisecond, 0)
+	cfg := Config{RPS: 10, Burst: 1, Per: "ip"}
+
+	s.Allow("k1", cfg)
+	if len(s.Snapshot()) != 1 {
+		t.Fatalf("expected 1 entry before TTL elapses, got %d", len(s.Snapshot()))
+	}
+
+	time.Sleep(30 * time.Millisecond)
+
+	if snap := s.Snapshot(); len(snap) != 0 {
+		t.Errorf("expected idle entry to be evicted after TTL, got %d entries", len(snap))
+	}
+}
+
+func TestStore_EvictsOldestWhenOverCapacity(t *testing.T) {
+	s := NewStore(time.Minute, 2)
+	cfg := Config{RPS: 10, Burst: 1, Per: "ip"}
+
+	s.Allow("k1", cfg)
+	s.Allow("k2", cfg)
+	s.Allow("k3", cfg) // should evict k1, the least recently used
+
+	snap := s.Snapshot()
+	if len(snap) != 2 {
+		t.Fatalf("expected store capped at 2 entries, got %d", len(snap))
+	}
+	for _, entry := range snap {
+		if entry.Key == "k1" {
+			t.Error("k1 should have been evicted once maxEntries was exceeded")
+		}
+	}
+}
+
+func TestStore_SnapshotReflectsConfig(t *testing.T) {
+	s := NewStore(time.Minute, 0)
+	s.Allow("k", Config{RPS: 5, Burst: 10, Per: "global"})
+
+	snap := s.Snapshot()
+	if len(snap) != 1 {
+		t.Fatalf("expected 1 entry, got %d", len(snap))
+	}
+	if snap[0].RPS != 5 || snap[0].Burst != 10 || snap[0].Per != "global" {
+		t.Errorf("got %+v", snap[0])
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+)
+
+// Rule is one entry of the ordered redirect_mapping.json rule list. Host and
+// Path may be a glob ("*", "api.*"), a literal, or — prefixed with "re:" — an
+// arbitrary regexp; Host matches the whole string, Path matches as a prefix
+// unless given as "re:". Port matches exactly and Method case-insensitively;
+// empty or "*" means "any". Rules are evaluated in file order, first match wins.
+type Rule struct {
+	Host   string `json:"host"`
+	Port   string `json:"port"`
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	Target string `json:"target"`
+	Mode   string `json:"mode"`
+
+	// 限流配置，留空则继承 rateLimitDefaults
+	RPS   float64 `json:"rps,omitempty"`
+	Burst int     `json:"burst,omitempty"`
+	Per   string  `json:"per,omitempty"`
+
+	// Key identifies this rule for metrics, rate limiting and backend
+	// backoff state. Computed by compile().
+	Key string `json:"-"`
+
+	hostRegex *regexp.Regexp
+	pathRegex *regexp.Regexp
+}
+
+// compile precompiles Host/Path into regexes, defaults Mode, and derives Key.
+// Must be called (holding redirectLock for writes) whenever Host/Port/
+// Method/Path change.
+func (rule *Rule) compile() error {
+	hostRe, err := compileHostPattern(rule.Host)
+	if err != nil {
+		return fmt.Errorf("invalid host pattern %q: %w", rule.Host, err)
+	}
+	pathRe, err := compilePathPattern(rule.Path)
+	if err != nil {
+		return fmt.Errorf("invalid path pattern %q: %w", rule.Path, err)
+	}
+
+	rule.hostRegex = hostRe
+	rule.pathRegex = pathRe
+	if rule.Mode == "" {
+		rule.Mode = redirectModeRedirect
+	}
+	rule.Key = fmt.Sprintf("%s|%s|%s|%s",
+		orStar(rule.Host), orStar(rule.Port), orStar(rule.Method), orStar(rule.Path))
+	return nil
+}
+
+func orStar(s string) string {
+	if s == "" {
+		return "*"
+	}
+	return s
+}
+
+// compileHostPattern compiles a host glob/regex. A nil, no-error result
+// means "match any host".
+func compileHostPattern(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" || pattern == "*" {
+		return nil, nil
+	}
+	if re, ok := strings.CutPrefix(pattern, "re:"); ok {
+		return regexp.Compile(re)
+	}
+	return regexp.Compile("^" + globToRegex(pattern) + "$")
+}
+
+// compilePathPattern compiles a path prefix/regex. A nil, no-error result
+// means "match any path".
+func compilePathPattern(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	if re, ok := strings.CutPrefix(pattern, "re:"); ok {
+		return regexp.Compile(re)
+	}
+	return regexp.Compile("^" + globToRegex(pattern))
+}
+
+// globToRegex escapes pattern for use in a regexp, then turns "*" back into
+// ".*" so simple globs like "api.*" or "*.example.com" keep working.
+func globToRegex(pattern string) string {
+	escaped := regexp.QuoteMeta(pattern)
+	return strings.ReplaceAll(escaped, `\*`, ".*")
+}
+
+// matchRedirectRule returns the first rule (in redirectRules order) whose
+// host/port/method/path all match. Callers must hold redirectLock.
+func matchRedirectRule(host, port, method, path string) (Rule, bool) {
+	for _, rule := range redirectRules {
+		if rule.Port != "" && rule.Port != "*" && rule.Port != port {
+			continue
+		}
+		if rule.hostRegex != nil && !rule.hostRegex.MatchString(host) {
+			continue
+		}
+		if rule.Method != "" && rule.Method != "*" && !strings.EqualFold(rule.Method, method) {
+			continue
+		}
+		if rule.pathRegex != nil && !rule.pathRegex.MatchString(path) {
+			continue
+		}
+		return rule, true
+	}
+	return Rule{}, false
+}
+
+// parseRuleObject decodes one element of the new array-of-rules form of
+// redirect_mapping.json. Port accepts either a JSON string or number.
+func parseRuleObject(msg json.RawMessage) (Rule, error) {
+	var raw struct {
+		Host   string          `json:"host"`
+		Port   json.RawMessage `json:"port"`
+		Method string          `json:"method"`
+		Path   string          `json:"path"`
+		Target string          `json:"target"`
+		Mode   string          `json:"mode"`
+		RPS    float64         `json:"rps"`
+		Burst  int             `json:"burst"`
+		Per    string          `json:"per"`
+	}
+	if err := json.Unmarshal(msg, &raw); err != nil {
+		return Rule{}, err
+	}
+
+	port, err := decodePortField(raw.Port)
+	if err != nil {
+		return Rule{}, err
+	}
+
+	return Rule{
+		Host: raw.Host, Port: port, Method: raw.Method, Path: raw.Path,
+		Target: raw.Target, Mode: raw.Mode, RPS: raw.RPS, Burst: raw.Burst, Per: raw.Per,
+	}, nil
+}
+
+// decodePortField accepts a JSON string or number for the "port" field.
+func decodePortField(raw json.RawMessage) (string, error) {
+	if len(raw) == 0 {
+		return "", nil
+	}
+
+	var asString string
+	if err := json.Unmarshal(raw, &asString); err == nil {
+		return asString, nil
+	}
+
+	var asNumber float64
+	if err := json.Unmarshal(raw, &asNumber); err == nil {
+		return fmt.Sprintf("%d", int(asNumber)), nil
+	}
+
+	return "", fmt.Errorf("port must be a string or number")
+}
+
+// promoteLegacyRule turns one entry of the old flat "*:PORT": target (or
+// "*:PORT": {target, mode, ...}) map form into a Rule with Host "*" and an
+// explicit Port, keeping back-compat with redirect_mapping.json files
+// written before rule objects existed.
+func promoteLegacyRule(key string, msg json.RawMessage) (Rule, error) {
+	_, port, err := net.SplitHostPort(key)
+	if err != nil {
+		return Rule{}, fmt.Errorf("invalid legacy key %q: %w", key, err)
+	}
+
+	var target string
+	if err := json.Unmarshal(msg, &target); err == nil {
+		return Rule{Host: "*", Port: port, Target: target, Mode: redirectModeRedirect}, nil
+	}
+
+	var obj struct {
+		Target string  `json:"target"`
+		Mode   string  `json:"mode"`
+		RPS    float64 `json:"rps"`
+		Burst  int     `json:"burst"`
+		Per    string  `json:"per"`
+	}
+	if err := json.Unmarshal(msg, &obj); err != nil {
+		return Rule{}, err
+	}
+
+	return Rule{
+		Host: "*", Port: port, Target: obj.Target, Mode: obj.Mode,
+		RPS: obj.RPS, Burst: obj.Burst, Per: obj.Per,
+	}, nil
+}
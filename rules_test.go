@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func compileRule(t *testing.T, rule Rule) Rule {
+	t.Helper()
+	if err := rule.compile(); err != nil {
+		t.Fatalf("compile(%+v): %v", rule, err)
+	}
+	return rule
+}
+
+func TestMatchRedirectRule_FirstMatchWins(t *testing.T) {
+	origRules := redirectRules
+	t.Cleanup(func() { redirectRules = origRules })
+
+	redirectRules = []Rule{
+		compileRule(t, Rule{Host: "api.example.com", Path: "/v1/", Target: "v1-backend:9000"}),
+		compileRule(t, Rule{Host: "api.example.com", Target: "catch-all-backend:9000"}),
+		compileRule(t, Rule{Host: "*", Port: "9093", Target: "legacy-backend:9093"}),
+	}
+
+	tests := []struct {
+		name           string
+		host, port     string
+		method, path   string
+		wantMatch      bool
+		wantTargetLike string
+	}{
+		{"path-specific rule wins over host catch-all", "api.example.com", "443", "GET", "/v1/users", true, "v1-backend:9000"},
+		{"falls through to host catch-all", "api.example.com", "443", "GET", "/v2/users", true, "catch-all-backend:9000"},
+		{"falls through to port-only legacy rule", "other.example.com", "9093", "GET", "/", true, "legacy-backend:9093"},
+		{"no rule matches", "other.example.com", "1234", "GET", "/", false, ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rule, ok := matchRedirectRule(tc.host, tc.port, tc.method, tc.path)
+			if ok != tc.wantMatch {
+				t.Fatalf("matched = %v, want %v", ok, tc.wantMatch)
+			}
+			if ok && rule.Target != tc.wantTargetLike {
+				t.Errorf("target = %q, want %q", rule.Target, tc.wantTargetLike)
+			}
+		})
+	}
+}
+
+func TestMatchRedirectRule_MethodAndPathFiltering(t *testing.T) {
+	origRules := redirectRules
+	t.Cleanup(func() { redirectRules = origRules })
+
+	redirectRules = []Rule{
+		compileRule(t, Rule{Method: "POST", Path: "/webhook", Target: "webhook-backend:8080"}),
+	}
+
+	if _, ok := matchRedirectRule("any-host", "443", "GET", "/webhook"); ok {
+		t.Error("GET should not match a POST-only rule")
+	}
+	if _, ok := matchRedirectRule("any-host", "443", "POST", "/other"); ok {
+		t.Error("/other should not match a /webhook-only rule")
+	}
+	if _, ok := matchRedirectRule("any-host", "443", "post", "/webhook"); !ok {
+		t.Error("method match should be case-insensitive")
+	}
+}
+
+func TestPromoteLegacyRule_PlainStringTarget(t *testing.T) {
+	msg, _ := json.Marshal("backend:9093")
+	rule, err := promoteLegacyRule("*:9093", msg)
+	if err != nil {
+		t.Fatalf("promoteLegacyRule: %v", err)
+	}
+	if rule.Host != "*" || rule.Port != "9093" || rule.Target != "backend:9093" {
+		t.Errorf("got %+v", rule)
+	}
+	if rule.Mode != redirectModeRedirect {
+		t.Errorf("Mode = %q, want %q", rule.Mode, redirectModeRedirect)
+	}
+}
+
+func TestPromoteLegacyRule_ObjectTarget(t *testing.T) {
+	msg := json.RawMessage(`{"target":"backend:9093","mode":"proxy","rps":5,"burst":10,"per":"ip"}`)
+	rule, err := promoteLegacyRule("*:9093", msg)
+	if err != nil {
+		t.Fatalf("promoteLegacyRule: %v", err)
+	}
+	if rule.Target != "backend:9093" || rule.Mode != "proxy" || rule.RPS != 5 || rule.Burst != 10 || rule.Per != "ip" {
+		t.Errorf("got %+v", rule)
+	}
+}
+
+func TestPromoteLegacyRule_InvalidKey(t *testing.T) {
+	msg, _ := json.Marshal("backend:9093")
+	if _, err := promoteLegacyRule("not-a-host-port", msg); err == nil {
+		t.Error("expected an error for a key with no port")
+	}
+}
+
+func TestDecodePortField(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    string
+		wantErr bool
+	}{
+		{"string port", `"9093"`, "9093", false},
+		{"numeric port", `9093`, "9093", false},
+		{"empty", ``, "", false},
+		{"invalid type", `true`, "", true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := decodePortField(json.RawMessage(tc.raw))
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tc.wantErr)
+			}
+			if got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRuleCompile_GlobHostPattern(t *testing.T) {
+	rule := compileRule(t, Rule{Host: "*.example.com", Target: "backend:9000"})
+
+	if _, ok := matchRuleHost(t, rule, "api.example.com"); !ok {
+		t.Error("expected api.example.com to match *.example.com")
+	}
+	if _, ok := matchRuleHost(t, rule, "example.com"); ok {
+		t.Error("bare example.com should not match *.example.com")
+	}
+}
+
+// matchRuleHost is a small helper to exercise rule.hostRegex without
+// threading port/method/path through matchRedirectRule.
+func matchRuleHost(t *testing.T, rule Rule, host string) (Rule, bool) {
+	t.Helper()
+	if rule.hostRegex == nil {
+		return rule, true
+	}
+	return rule, rule.hostRegex.MatchString(host)
+}
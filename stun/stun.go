@@ -0,0 +1,231 @@
+// Package stun implements the minimal subset of RFC 5389 needed to learn our
+// own external (NAT-mapped) address: building a Binding Request and parsing
+// the XOR-MAPPED-ADDRESS attribute out of the Binding Success Response.
+package stun
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+)
+
+const (
+	magicCookie            uint32 = 0x2112A442
+	bindingRequestType     uint16 = 0x0001
+	bindingSuccessResponse uint16 = 0x0101
+	attrMappedAddress      uint16 = 0x0001
+	attrXorMappedAddress   uint16 = 0x0020
+	ipv4Family             byte   = 0x01
+	ipv6Family             byte   = 0x02
+)
+
+// MappedAddress is the external address a STUN server observed for us.
+type MappedAddress struct {
+	IP   net.IP
+	Port int
+}
+
+// Result is the outcome of a successful Binding Request against one server.
+type Result struct {
+	Addr   MappedAddress
+	Server string
+	RTT    time.Duration
+}
+
+// Client sends STUN Binding Requests over UDP with a fixed per-request timeout.
+type Client struct {
+	Timeout time.Duration
+}
+
+// NewClient returns a Client with the given per-request timeout, defaulting
+// to 3 seconds when timeout is zero or negative.
+func NewClient(timeout time.Duration) *Client {
+	if timeout <= 0 {
+		timeout = 3 * time.Second
+	}
+	return &Client{Timeout: timeout}
+}
+
+// Discover tries each server in order and returns the first successful
+// Binding Request result. Callers that want failover/backoff across rounds
+// should wrap this in their own retry loop.
+func (c *Client) Discover(servers []string) (*Result, error) {
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("stun: no servers configured")
+	}
+
+	var lastErr error
+	for _, server := range servers {
+		result, err := c.Query(server)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return result, nil
+	}
+	return nil, lastErr
+}
+
+// Query sends a single Binding Request to server ("host:port") and parses
+// the response.
+func (c *Client) Query(server string) (*Result, error) {
+	conn, err := net.DialTimeout("udp", server, c.Timeout)
+	if err != nil {
+		return nil, fmt.Errorf("stun: dial %s: %w", server, err)
+	}
+	defer conn.Close()
+
+	var transactionID [12]byte
+	if _, err := rand.Read(transactionID[:]); err != nil {
+		return nil, fmt.Errorf("stun: generate transaction ID: %w", err)
+	}
+
+	if err := conn.SetDeadline(time.Now().Add(c.Timeout)); err != nil {
+		return nil, fmt.Errorf("stun: set deadline: %w", err)
+	}
+
+	start := time.Now()
+	if _, err := conn.Write(buildBindingRequest(transactionID)); err != nil {
+		return nil, fmt.Errorf("stun: write to %s: %w", server, err)
+	}
+
+	resp := make([]byte, 1500)
+	n, err := conn.Read(resp)
+	if err != nil {
+		return nil, fmt.Errorf("stun: read from %s: %w", server, err)
+	}
+	rtt := time.Since(start)
+
+	addr, err := parseBindingResponse(resp[:n], transactionID)
+	if err != nil {
+		return nil, fmt.Errorf("stun: %s: %w", server, err)
+	}
+
+	return &Result{Addr: *addr, Server: server, RTT: rtt}, nil
+}
+
+// buildBindingRequest builds the 20-byte STUN header for a Binding Request
+// with no attributes.
+func buildBindingRequest(transactionID [12]byte) []byte {
+	msg := make([]byte, 20)
+	binary.BigEndian.PutUint16(msg[0:2], bindingRequestType)
+	binary.BigEndian.PutUint16(msg[2:4], 0)
+	binary.BigEndian.PutUint32(msg[4:8], magicCookie)
+	copy(msg[8:20], transactionID[:])
+	return msg
+}
+
+// parseBindingResponse validates the header against transactionID and walks
+// the TLV attribute list looking for XOR-MAPPED-ADDRESS (preferred) or the
+// older MAPPED-ADDRESS as a fallback.
+func parseBindingResponse(data []byte, transactionID [12]byte) (*MappedAddress, error) {
+	if len(data) < 20 {
+		return nil, fmt.Errorf("response too short (%d bytes)", len(data))
+	}
+	if msgType := binary.BigEndian.Uint16(data[0:2]); msgType != bindingSuccessResponse {
+		return nil, fmt.Errorf("unexpected message type 0x%04x", msgType)
+	}
+	if binary.BigEndian.Uint32(data[4:8]) != magicCookie {
+		return nil, fmt.Errorf("bad magic cookie")
+	}
+	if !bytes.Equal(data[8:20], transactionID[:]) {
+		return nil, fmt.Errorf("transaction ID mismatch")
+	}
+
+	msgLen := int(binary.BigEndian.Uint16(data[2:4]))
+	body := data[20:]
+	if len(body) < msgLen {
+		return nil, fmt.Errorf("truncated attribute section")
+	}
+	body = body[:msgLen]
+
+	var xorAddr, plainAddr *MappedAddress
+	for len(body) >= 4 {
+		attrType := binary.BigEndian.Uint16(body[0:2])
+		attrLen := int(binary.BigEndian.Uint16(body[2:4]))
+		if len(body) < 4+attrLen {
+			break
+		}
+		value := body[4 : 4+attrLen]
+
+		switch attrType {
+		case attrXorMappedAddress:
+			if addr, err := decodeXorMappedAddress(value, transactionID); err == nil {
+				xorAddr = addr
+			}
+		case attrMappedAddress:
+			if addr, err := decodeMappedAddress(value); err == nil {
+				plainAddr = addr
+			}
+		}
+
+		// Attributes are padded to a 4-byte boundary.
+		padded := attrLen
+		if rem := padded % 4; rem != 0 {
+			padded += 4 - rem
+		}
+		body = body[4+padded:]
+	}
+
+	if xorAddr != nil {
+		return xorAddr, nil
+	}
+	if plainAddr != nil {
+		return plainAddr, nil
+	}
+	return nil, fmt.Errorf("no mapped address attribute in response")
+}
+
+// decodeXorMappedAddress decodes an XOR-MAPPED-ADDRESS attribute value. The
+// port is XOR'd with the high 16 bits of the magic cookie; the IPv4 address
+// is XOR'd with the magic cookie; the IPv6 address is XOR'd with the magic
+// cookie followed by the transaction ID.
+func decodeXorMappedAddress(v []byte, transactionID [12]byte) (*MappedAddress, error) {
+	if len(v) < 4 {
+		return nil, fmt.Errorf("XOR-MAPPED-ADDRESS too short")
+	}
+	family := v[1]
+	port := binary.BigEndian.Uint16(v[2:4]) ^ uint16(magicCookie>>16)
+
+	switch family {
+	case ipv4Family:
+		if len(v) < 8 {
+			return nil, fmt.Errorf("XOR-MAPPED-ADDRESS IPv4 payload too short")
+		}
+		raw := binary.BigEndian.Uint32(v[4:8]) ^ magicCookie
+		ip := make(net.IP, 4)
+		binary.BigEndian.PutUint32(ip, raw)
+		return &MappedAddress{IP: ip, Port: int(port)}, nil
+	case ipv6Family:
+		if len(v) < 20 {
+			return nil, fmt.Errorf("XOR-MAPPED-ADDRESS IPv6 payload too short")
+		}
+		xorKey := make([]byte, 16)
+		binary.BigEndian.PutUint32(xorKey[0:4], magicCookie)
+		copy(xorKey[4:16], transactionID[:])
+		ip := make(net.IP, 16)
+		for i := range ip {
+			ip[i] = v[4+i] ^ xorKey[i]
+		}
+		return &MappedAddress{IP: ip, Port: int(port)}, nil
+	default:
+		return nil, fmt.Errorf("unknown address family 0x%02x", family)
+	}
+}
+
+// decodeMappedAddress decodes the older, non-XOR'd MAPPED-ADDRESS attribute.
+func decodeMappedAddress(v []byte) (*MappedAddress, error) {
+	if len(v) < 8 {
+		return nil, fmt.Errorf("MAPPED-ADDRESS too short")
+	}
+	if family := v[1]; family != ipv4Family {
+		return nil, fmt.Errorf("unsupported address family 0x%02x", family)
+	}
+	port := binary.BigEndian.Uint16(v[2:4])
+	ip := make(net.IP, 4)
+	copy(ip, v[4:8])
+	return &MappedAddress{IP: ip, Port: int(port)}, nil
+}
@@ -0,0 +1,176 @@
+package stun
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+// buildXorMappedAddressAttr builds a well-formed XOR-MAPPED-ADDRESS attribute
+// value (family + XOR'd port + XOR'd address) for the given IP/port.
+func buildXorMappedAddressAttr(t *testing.T, transactionID [12]byte, ip net.IP, port uint16) []byte {
+	t.Helper()
+
+	xorPort := port ^ uint16(magicCookie>>16)
+	if ip4 := ip.To4(); ip4 != nil {
+		v := make([]byte, 8)
+		v[1] = ipv4Family
+		binary.BigEndian.PutUint16(v[2:4], xorPort)
+		raw := binary.BigEndian.Uint32(ip4) ^ magicCookie
+		binary.BigEndian.PutUint32(v[4:8], raw)
+		return v
+	}
+
+	ip16 := ip.To16()
+	v := make([]byte, 20)
+	v[1] = ipv6Family
+	binary.BigEndian.PutUint16(v[2:4], xorPort)
+	xorKey := make([]byte, 16)
+	binary.BigEndian.PutUint32(xorKey[0:4], magicCookie)
+	copy(xorKey[4:16], transactionID[:])
+	for i := 0; i < 16; i++ {
+		v[4+i] = ip16[i] ^ xorKey[i]
+	}
+	return v
+}
+
+// buildBindingSuccessResponse assembles a full Binding Success Response
+// carrying a single attribute (attrType, value), padded to a 4-byte
+// boundary as the wire format requires.
+func buildBindingSuccessResponse(transactionID [12]byte, attrType uint16, value []byte) []byte {
+	padded := len(value)
+	if rem := padded % 4; rem != 0 {
+		padded += 4 - rem
+	}
+	attrs := make([]byte, 4+padded)
+	binary.BigEndian.PutUint16(attrs[0:2], attrType)
+	binary.BigEndian.PutUint16(attrs[2:4], uint16(len(value)))
+	copy(attrs[4:], value)
+
+	msg := make([]byte, 20+len(attrs))
+	binary.BigEndian.PutUint16(msg[0:2], bindingSuccessResponse)
+	binary.BigEndian.PutUint16(msg[2:4], uint16(len(attrs)))
+	binary.BigEndian.PutUint32(msg[4:8], magicCookie)
+	copy(msg[8:20], transactionID[:])
+	copy(msg[20:], attrs)
+	return msg
+}
+
+func TestParseBindingResponse_XorMappedAddressIPv4(t *testing.T) {
+	var transactionID [12]byte
+	copy(transactionID[:], []byte("abcdefghijkl"))
+
+	wantIP := net.ParseIP("203.0.113.42").To4()
+	wantPort := uint16(51820)
+
+	attr := buildXorMappedAddressAttr(t, transactionID, wantIP, wantPort)
+	msg := buildBindingSuccessResponse(transactionID, attrXorMappedAddress, attr)
+
+	addr, err := parseBindingResponse(msg, transactionID)
+	if err != nil {
+		t.Fatalf("parseBindingResponse: %v", err)
+	}
+	if !addr.IP.Equal(wantIP) {
+		t.Errorf("IP = %v, want %v", addr.IP, wantIP)
+	}
+	if addr.Port != int(wantPort) {
+		t.Errorf("Port = %d, want %d", addr.Port, wantPort)
+	}
+}
+
+func TestParseBindingResponse_XorMappedAddressIPv6(t *testing.T) {
+	var transactionID [12]byte
+	copy(transactionID[:], []byte("123456789012"))
+
+	wantIP := net.ParseIP("2001:db8::1")
+	wantPort := uint16(3478)
+
+	attr := buildXorMappedAddressAttr(t, transactionID, wantIP, wantPort)
+	msg := buildBindingSuccessResponse(transactionID, attrXorMappedAddress, attr)
+
+	addr, err := parseBindingResponse(msg, transactionID)
+	if err != nil {
+		t.Fatalf("parseBindingResponse: %v", err)
+	}
+	if !addr.IP.Equal(wantIP) {
+		t.Errorf("IP = %v, want %v", addr.IP, wantIP)
+	}
+	if addr.Port != int(wantPort) {
+		t.Errorf("Port = %d, want %d", addr.Port, wantPort)
+	}
+}
+
+func TestParseBindingResponse_FallsBackToMappedAddress(t *testing.T) {
+	var transactionID [12]byte
+	copy(transactionID[:], []byte("legacyserver"))
+
+	wantIP := net.ParseIP("198.51.100.7").To4()
+	wantPort := uint16(4500)
+
+	attr := make([]byte, 8)
+	attr[1] = ipv4Family
+	binary.BigEndian.PutUint16(attr[2:4], wantPort)
+	copy(attr[4:8], wantIP)
+
+	msg := buildBindingSuccessResponse(transactionID, attrMappedAddress, attr)
+
+	addr, err := parseBindingResponse(msg, transactionID)
+	if err != nil {
+		t.Fatalf("parseBindingResponse: %v", err)
+	}
+	if !addr.IP.Equal(wantIP) {
+		t.Errorf("IP = %v, want %v", addr.IP, wantIP)
+	}
+	if addr.Port != int(wantPort) {
+		t.Errorf("Port = %d, want %d", addr.Port, wantPort)
+	}
+}
+
+func TestParseBindingResponse_Errors(t *testing.T) {
+	var transactionID [12]byte
+	copy(transactionID[:], []byte("abcdefghijkl"))
+
+	var otherID [12]byte
+	copy(otherID[:], []byte("mismatchedid"))
+
+	goodAttr := buildXorMappedAddressAttr(t, transactionID, net.ParseIP("10.0.0.1").To4(), 1234)
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"too short", []byte{0x01, 0x01, 0x00}},
+		{"wrong message type", func() []byte {
+			msg := buildBindingSuccessResponse(transactionID, attrXorMappedAddress, goodAttr)
+			binary.BigEndian.PutUint16(msg[0:2], bindingRequestType)
+			return msg
+		}()},
+		{"bad magic cookie", func() []byte {
+			msg := buildBindingSuccessResponse(transactionID, attrXorMappedAddress, goodAttr)
+			binary.BigEndian.PutUint32(msg[4:8], 0xdeadbeef)
+			return msg
+		}()},
+		{"transaction ID mismatch", buildBindingSuccessResponse(otherID, attrXorMappedAddress, goodAttr)},
+		{"truncated attribute section", func() []byte {
+			msg := buildBindingSuccessResponse(transactionID, attrXorMappedAddress, goodAttr)
+			binary.BigEndian.PutUint16(msg[2:4], 0xffff)
+			return msg
+		}()},
+		{"no mapped address attribute", buildBindingSuccessResponse(transactionID, 0x8022, []byte("software"))},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := parseBindingResponse(tc.data, transactionID); err == nil {
+				t.Errorf("expected an error, got nil")
+			}
+		})
+	}
+}
+
+func TestClientDiscover_NoServers(t *testing.T) {
+	c := NewClient(0)
+	if _, err := c.Discover(nil); err == nil {
+		t.Error("expected an error for an empty server list")
+	}
+}